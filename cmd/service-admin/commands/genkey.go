@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// mfaKeyFile is where the AES-256 key used to encrypt TOTP secrets at rest
+// is written. It is hex-encoded so it can also be dropped straight into an
+// env var for local development.
+const mfaKeyFile = "mfa.key"
+
+// keysDir holds every signing key this service has ever generated, named
+// "<kid>.private.pem"/"<kid>.public.pem", plus an "active" file naming the
+// kid access tokens are currently signed with. See auth.LoadKeyStore.
+const keysDir = "keys"
+
+// GenKey adds a new RSA signing key under keysDir and makes it the active
+// one, plus (re)generates the MFA secret-encryption key (mfa.key). It
+// exists for bootstrapping a fresh environment; operators rotating an
+// existing deployment should use GenSigningKey or GenMFAKey instead, since
+// running both together invalidates every outstanding TOTP enrollment even
+// if only the signing key needed rotating.
+func GenKey() error {
+	if err := GenSigningKey(); err != nil {
+		return err
+	}
+
+	return GenMFAKey()
+}
+
+// GenSigningKey adds a new RSA signing key under keysDir and makes it the
+// active one. Previously generated signing keys are left on disk so access
+// tokens minted before the rotation keep verifying until they expire. This
+// is the routine rotation operators should run periodically; it has no
+// effect on TOTP enrollments.
+func GenSigningKey() error {
+	if err := os.MkdirAll(keysDir, 0700); err != nil {
+		return errors.Wrap(err, "creating keys directory")
+	}
+
+	kid, err := randomKID()
+	if err != nil {
+		return errors.Wrap(err, "generating kid")
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return errors.Wrap(err, "generating private key")
+	}
+
+	privatePath := filepath.Join(keysDir, kid+".private.pem")
+	privateFile, err := os.Create(privatePath)
+	if err != nil {
+		return errors.Wrap(err, "creating private key file")
+	}
+	defer privateFile.Close()
+
+	privateBlock := pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	}
+	if err := pem.Encode(privateFile, &privateBlock); err != nil {
+		return errors.Wrap(err, "encoding private key file")
+	}
+
+	publicPath := filepath.Join(keysDir, kid+".public.pem")
+	publicFile, err := os.Create(publicPath)
+	if err != nil {
+		return errors.Wrap(err, "creating public key file")
+	}
+	defer publicFile.Close()
+
+	asn1Bytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "marshalling public key")
+	}
+
+	publicBlock := pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: asn1Bytes,
+	}
+	if err := pem.Encode(publicFile, &publicBlock); err != nil {
+		return errors.Wrap(err, "encoding public key file")
+	}
+
+	activePath := filepath.Join(keysDir, "active")
+	if err := os.WriteFile(activePath, []byte(kid), 0600); err != nil {
+		return errors.Wrap(err, "marking key active")
+	}
+
+	return nil
+}
+
+// GenMFAKey writes a fresh random 256-bit key to mfaKeyFile, for use with
+// service.WithMFAEncryptionKey. Unlike GenSigningKey, this has no grace
+// period: rotating it invalidates every outstanding TOTP enrollment, since
+// secrets encrypted under the old key can no longer be decrypted.
+func GenMFAKey() error {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return errors.Wrap(err, "generating mfa key")
+	}
+
+	if err := os.WriteFile(mfaKeyFile, []byte(hex.EncodeToString(key[:])), 0600); err != nil {
+		return errors.Wrap(err, "writing mfa key file")
+	}
+
+	return nil
+}
+
+// randomKID generates a short random key id to name a signing key by.
+func randomKID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
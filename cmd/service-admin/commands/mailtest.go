@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/santiagoh1997/service-template/internal/business/mail"
+	"github.com/santiagoh1997/service-template/internal/business/mail/templates"
+)
+
+// MailTest sends a sample verification email to `to` through the given
+// SMTP relay, so operators can confirm mail credentials/templates are
+// working without going through the signup flow.
+func MailTest(cfg mail.SMTPConfig, to string) error {
+	if to == "" {
+		return errors.New("mail-test requires a recipient: mail-test <to>")
+	}
+
+	html, text, err := templates.Verification(templates.VerificationData{
+		Name: "there",
+		URL:  "https://example.com/verify-email?token=test",
+	})
+	if err != nil {
+		return errors.Wrap(err, "rendering test email")
+	}
+
+	sender := mail.NewSMTPSender(cfg)
+	msg := mail.Message{
+		To:      to,
+		Subject: "service-admin mail-test",
+		HTML:    html,
+		Text:    text,
+	}
+
+	if err := sender.Send(context.Background(), msg); err != nil {
+		return errors.Wrap(err, "sending test email")
+	}
+
+	return nil
+}
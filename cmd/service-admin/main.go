@@ -8,6 +8,7 @@ import (
 	"github.com/ardanlabs/conf"
 	"github.com/pkg/errors"
 	"github.com/santiagoh1997/service-template/cmd/service-admin/commands"
+	"github.com/santiagoh1997/service-template/internal/business/mail"
 	"github.com/santiagoh1997/service-template/internal/foundation/database"
 )
 
@@ -40,6 +41,13 @@ func run(log *log.Logger) error {
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:true"`
 		}
+		SMTP struct {
+			Host     string `conf:"default:localhost"`
+			Port     int    `conf:"default:1025"`
+			Username string `conf:"default:"`
+			Password string `conf:"default:,noprint"`
+			From     string `conf:"default:no-reply@example.com"`
+		}
 	}
 	cfg.Version.SVN = build
 	cfg.Version.Desc = "copyright information here"
@@ -94,14 +102,82 @@ func run(log *log.Logger) error {
 		}
 
 	case "genkey":
-		if err := commands.GenKey(); err != nil {
-			return errors.Wrap(err, "key generation")
+		switch cfg.Args.Num(1) {
+		case "signing":
+			if err := commands.GenSigningKey(); err != nil {
+				return errors.Wrap(err, "signing key generation")
+			}
+
+		case "mfa":
+			if err := commands.GenMFAKey(); err != nil {
+				return errors.Wrap(err, "mfa key generation")
+			}
+
+		case "all":
+			if err := commands.GenKey(); err != nil {
+				return errors.Wrap(err, "key generation")
+			}
+
+		default:
+			fmt.Println("genkey signing: rotate the JWT signing key, keeping old keys around to verify outstanding tokens")
+			fmt.Println("genkey mfa: rotate the TOTP secret-encryption key (invalidates outstanding TOTP enrollments)")
+			fmt.Println("genkey all: bootstrap a fresh environment by generating both")
+			return commands.ErrHelp
+		}
+
+	case "client":
+		switch cfg.Args.Num(1) {
+		case "create":
+			name := cfg.Args.Num(2)
+			domain := cfg.Args.Num(3)
+			ownerUserID := cfg.Args.Num(4)
+			if err := commands.ClientCreate(dbConfig, name, domain, ownerUserID, nil); err != nil {
+				return errors.Wrap(err, "creating client")
+			}
+
+		case "list":
+			if err := commands.ClientList(dbConfig); err != nil {
+				return errors.Wrap(err, "listing clients")
+			}
+
+		case "rotate":
+			clientID := cfg.Args.Num(2)
+			if err := commands.ClientRotate(dbConfig, clientID); err != nil {
+				return errors.Wrap(err, "rotating client secret")
+			}
+
+		case "revoke":
+			clientID := cfg.Args.Num(2)
+			if err := commands.ClientRevoke(dbConfig, clientID); err != nil {
+				return errors.Wrap(err, "revoking client")
+			}
+
+		default:
+			fmt.Println("client create <name> <domain> <owner_user_id>: register a new app client")
+			fmt.Println("client list: list every registered app client")
+			fmt.Println("client rotate <client_id>: issue a new secret for a client")
+			fmt.Println("client revoke <client_id>: deactivate a client")
+			return commands.ErrHelp
+		}
+
+	case "mail-test":
+		smtpConfig := mail.SMTPConfig{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+		}
+		if err := commands.MailTest(smtpConfig, cfg.Args.Num(1)); err != nil {
+			return errors.Wrap(err, "sending test email")
 		}
 
 	default:
 		fmt.Println("migrate: create the schema in the database")
 		fmt.Println("seed: add data to the database")
-		fmt.Println("genkey: generate a set of private/public key files")
+		fmt.Println("genkey: rotate signing/mfa keys (signing, mfa, all); run without arguments for details")
+		fmt.Println("mail-test: send a sample email through the configured SMTP relay")
+		fmt.Println("client: manage app clients (create, list, rotate, revoke)")
 		fmt.Println("provide a command to get more help.")
 		return commands.ErrHelp
 	}
@@ -0,0 +1,65 @@
+// Package auth provides authentication and authorization support for the
+// service. It wraps JWT claims with the roles our access control policies
+// check against.
+package auth
+
+import (
+	"github.com/dgrijalva/jwt-go"
+)
+
+// These are the expected values for Roles.
+const (
+	RoleAdmin = "ADMIN"
+	RoleUser  = "USER"
+)
+
+// Claims represents the authorization claims transmitted via a JWT.
+type Claims struct {
+	jwt.StandardClaims
+	Roles []string `json:"roles"`
+
+	// AMR lists the authentication methods references used to establish
+	// this claim, e.g. "pwd" for password and "otp" for a TOTP code.
+	AMR []string `json:"amr,omitempty"`
+
+	// Ticket identifies an in-progress multi-factor login. The service
+	// package sets it on none of the claims it mints: Authenticate hands the
+	// ticket back in a *service.MFARequiredError, to be redeemed via
+	// AuthenticateMFA before it expires.
+	Ticket string `json:"ticket,omitempty"`
+
+	// Scope lists the fine-grained permissions this claim carries,
+	// independent of Roles. It's how app clients (machine-to-machine
+	// credentials with no role of their own) are authorized.
+	Scope []string `json:"scope,omitempty"`
+}
+
+// HasRole returns true if the claims carries at least one of the given
+// roles. Use this (not HasScope) to gate user-only actions: roles and
+// scopes are deliberately checked separately, since Roles identifies a
+// logged-in user and Scope identifies an app client's permissions, and the
+// two are independent namespaces that may otherwise collide (e.g. a client
+// provisioned with perms=["ADMIN"] must not thereby gain the ADMIN role).
+func (c Claims) HasRole(roles ...string) bool {
+	for _, want := range roles {
+		for _, has := range c.Roles {
+			if has == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasScope returns true if the claims carries at least one of the given
+// scopes. See HasRole for why this is kept separate from role checks.
+func (c Claims) HasScope(scopes ...string) bool {
+	for _, want := range scopes {
+		for _, has := range c.Scope {
+			if has == want {
+				return true
+			}
+		}
+	}
+	return false
+}
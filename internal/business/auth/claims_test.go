@@ -0,0 +1,87 @@
+package auth
+
+import "testing"
+
+func TestClaimsHasRole(t *testing.T) {
+	cases := []struct {
+		name   string
+		claims Claims
+		roles  []string
+		want   bool
+	}{
+		{
+			name:   "matches role",
+			claims: Claims{Roles: []string{RoleAdmin}},
+			roles:  []string{RoleAdmin},
+			want:   true,
+		},
+		{
+			name:   "matches one of several roles",
+			claims: Claims{Roles: []string{RoleUser}},
+			roles:  []string{RoleAdmin, RoleUser},
+			want:   true,
+		},
+		{
+			name:   "no match",
+			claims: Claims{Roles: []string{RoleUser}},
+			roles:  []string{RoleAdmin},
+			want:   false,
+		},
+		{
+			name:   "empty claims",
+			claims: Claims{},
+			roles:  []string{RoleAdmin},
+			want:   false,
+		},
+		{
+			name:   "scope does not satisfy a role check",
+			claims: Claims{Scope: []string{RoleAdmin}},
+			roles:  []string{RoleAdmin},
+			want:   false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.claims.HasRole(tc.roles...); got != tc.want {
+				t.Errorf("HasRole(%v) = %t, want %t", tc.roles, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClaimsHasScope(t *testing.T) {
+	cases := []struct {
+		name   string
+		claims Claims
+		scopes []string
+		want   bool
+	}{
+		{
+			name:   "matches scope",
+			claims: Claims{Scope: []string{"clients:write"}},
+			scopes: []string{"clients:write"},
+			want:   true,
+		},
+		{
+			name:   "no match",
+			claims: Claims{Scope: []string{"clients:read"}},
+			scopes: []string{"clients:write"},
+			want:   false,
+		},
+		{
+			name:   "role does not satisfy a scope check",
+			claims: Claims{Roles: []string{"clients:write"}},
+			scopes: []string{"clients:write"},
+			want:   false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.claims.HasScope(tc.scopes...); got != tc.want {
+				t.Errorf("HasScope(%v) = %t, want %t", tc.scopes, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownKID occurs when a JWT references a key id the KeyStore has no
+// record of, e.g. because it was signed by a key that has since been
+// retired.
+var ErrUnknownKID = errors.New("unknown signing key id")
+
+// KeyStore signs and verifies access tokens across multiple active RSA key
+// pairs, identified by a "kid" header on the JWT. This lets `genkey` add a
+// new signing key while old, still-unexpired access tokens continue to
+// verify against the previous one, and lets the public half be published
+// over HTTP via JWKS for downstream services to verify tokens themselves.
+type KeyStore interface {
+	// Sign mints a JWT for claims using the active signing key and returns
+	// the encoded token.
+	Sign(claims Claims) (string, error)
+
+	// Parse verifies token's signature against whichever of the store's
+	// keys matches its "kid" header and returns the decoded claims.
+	Parse(token string) (Claims, error)
+
+	// JWKS returns the public half of every active key, suitable for
+	// serving at a /.well-known/jwks.json style endpoint.
+	JWKS() JWKS
+}
+
+// keyStore is the default KeyStore, backed by a fixed set of RSA key pairs
+// loaded at startup (see the genkey command for how they're produced).
+type keyStore struct {
+	keys      map[string]*rsa.PrivateKey
+	activeKID string
+}
+
+// NewKeyStore returns a KeyStore that signs with the key named activeKID
+// and can verify tokens signed by any key in keys.
+func NewKeyStore(keys map[string]*rsa.PrivateKey, activeKID string) (KeyStore, error) {
+	if _, ok := keys[activeKID]; !ok {
+		return nil, errors.Errorf("active kid %q not present in key set", activeKID)
+	}
+
+	return keyStore{keys: keys, activeKID: activeKID}, nil
+}
+
+func (ks keyStore) Sign(claims Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = ks.activeKID
+
+	signed, err := token.SignedString(ks.keys[ks.activeKID])
+	if err != nil {
+		return "", errors.Wrap(err, "signing token")
+	}
+
+	return signed, nil
+}
+
+func (ks keyStore) Parse(tokenStr string) (Claims, error) {
+	var claims Claims
+
+	_, err := jwt.ParseWithClaims(tokenStr, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("token has no kid header")
+		}
+
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKID
+		}
+
+		return &key.PublicKey, nil
+	})
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "parsing token")
+	}
+
+	return claims, nil
+}
+
+func (ks keyStore) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+
+	for kid, key := range ks.keys {
+		pub := key.PublicKey
+		jwks.Keys = append(jwks.Keys, JWK{
+			Kid: kid,
+			Kty: "RSA",
+			Alg: "RS256",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+
+	return jwks
+}
+
+// JWK is the JSON representation of a single public key, per RFC 7517.
+type JWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set, the standard format for publishing a set of
+// public keys.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadKeyStore builds a KeyStore from a directory laid out by the genkey
+// command: one "<kid>.private.pem" file per signing key that has ever been
+// generated, plus an "active" file naming the kid new tokens are signed
+// with. Keys other than the active one are kept only to verify tokens
+// issued before the last rotation.
+func LoadKeyStore(dir string) (KeyStore, error) {
+	activeBytes, err := os.ReadFile(filepath.Join(dir, "active"))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading active kid")
+	}
+	activeKID := strings.TrimSpace(string(activeBytes))
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.private.pem"))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing signing keys")
+	}
+
+	keys := make(map[string]*rsa.PrivateKey, len(matches))
+	for _, path := range matches {
+		kid := strings.TrimSuffix(filepath.Base(path), ".private.pem")
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading key %s", kid)
+		}
+
+		block, _ := pem.Decode(raw)
+		if block == nil {
+			return nil, errors.Errorf("no PEM block found in %s", path)
+		}
+
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing key %s", kid)
+		}
+
+		keys[kid] = key
+	}
+
+	return NewKeyStore(keys, activeKID)
+}
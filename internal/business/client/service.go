@@ -0,0 +1,295 @@
+// Package client contains app-client related CRUD functionality: the
+// confidential OAuth2 client-credentials counterpart to the user-facing
+// service package, for service-to-service authentication.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"io"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/santiagoh1997/service-template/internal/business/auth"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrNotFound is used when a specific Client is requested but does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrAuthenticationFailure occurs when a client attempts to authenticate
+	// but the id/secret pair doesn't check out, or the client is inactive.
+	ErrAuthenticationFailure = errors.New("authentication failed")
+
+	// ErrForbidden occurs when a caller tries to manage a client they don't
+	// own and isn't an admin.
+	ErrForbidden = errors.New("attempted action is not allowed")
+)
+
+const clientTokenTTL = time.Hour
+
+// AccessToken is a short-lived, signed JWT minted for a client that
+// authenticated via the client-credentials grant, mirroring
+// service.AccessToken for user logins.
+type AccessToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// Client is a confidential or public app client authorized to obtain its
+// own access tokens via the OAuth2 client-credentials grant.
+type Client struct {
+	ID          string    `db:"client_id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	SecretHash  []byte    `db:"secret_hash" json:"-"`
+	Active      bool      `db:"active" json:"active"`
+	Public      bool      `db:"public" json:"public"`
+	Domain      string    `db:"domain" json:"domain"`
+	OwnerUserID string    `db:"owner_user_id" json:"owner_user_id"`
+	Perms       []string  `db:"perms" json:"perms"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewClientRequest contains information needed to register a new Client.
+type NewClientRequest struct {
+	Name        string   `json:"name" validate:"required"`
+	Domain      string   `json:"domain"`
+	Public      bool     `json:"public"`
+	OwnerUserID string   `json:"owner_user_id" validate:"required"`
+	Perms       []string `json:"perms"`
+}
+
+// ClientService manages the set of API's for app-client access.
+type ClientService interface {
+	Register(ctx context.Context, traceID string, ncr NewClientRequest, now time.Time) (Client, string, error)
+	RotateSecret(ctx context.Context, traceID string, claims auth.Claims, clientID string, now time.Time) (string, error)
+	Revoke(ctx context.Context, traceID string, claims auth.Claims, clientID string) error
+
+	// Authenticate verifies a client id/secret pair and, on success,
+	// returns a signed access token scoped to that client's permissions
+	// rather than any user role.
+	Authenticate(ctx context.Context, traceID string, clientID, clientSecret string, now time.Time) (AccessToken, error)
+}
+
+type clientService struct {
+	db   *sqlx.DB
+	keys auth.KeyStore
+}
+
+// Options configures optional dependencies of a ClientService at
+// construction time.
+type Options struct {
+	keyStore auth.KeyStore
+}
+
+// Option mutates Options.
+type Option func(*Options)
+
+// WithKeyStore sets the KeyStore used to sign client access tokens. See
+// auth.LoadKeyStore for loading one from the keys produced by the genkey
+// command.
+func WithKeyStore(ks auth.KeyStore) Option {
+	return func(o *Options) {
+		o.keyStore = ks
+	}
+}
+
+// errKeyStore is the default auth.KeyStore when NewBasicService is not
+// given WithKeyStore: Authenticate fails with a descriptive error instead
+// of signing with a nil KeyStore.
+type errKeyStore struct{}
+
+var errNoKeyStore = errors.New("no signing key configured: pass client.WithKeyStore")
+
+func (errKeyStore) Sign(claims auth.Claims) (string, error) {
+	return "", errNoKeyStore
+}
+
+func (errKeyStore) Parse(token string) (auth.Claims, error) {
+	return auth.Claims{}, errNoKeyStore
+}
+
+func (errKeyStore) JWKS() auth.JWKS {
+	return auth.JWKS{}
+}
+
+// NewBasicService constructs a ClientService for api access.
+func NewBasicService(db *sqlx.DB, opts ...Option) ClientService {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.keyStore == nil {
+		o.keyStore = errKeyStore{}
+	}
+
+	return clientService{db: db, keys: o.keyStore}
+}
+
+// Register creates a new app client, generating its client secret. The raw
+// secret is only ever returned here; only its bcrypt hash is persisted.
+func (cs clientService) Register(ctx context.Context, traceID string, ncr NewClientRequest, now time.Time) (Client, string, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.client.register")
+	defer span.End()
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return Client{}, "", err
+	}
+
+	c := Client{
+		ID:          uuid.New().String(),
+		Name:        ncr.Name,
+		SecretHash:  hash,
+		Active:      true,
+		Public:      ncr.Public,
+		Domain:      ncr.Domain,
+		OwnerUserID: ncr.OwnerUserID,
+		Perms:       ncr.Perms,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO clients
+		(client_id, name, secret_hash, active, public, domain, owner_user_id, perms, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	if _, err := cs.db.ExecContext(ctx, q, c.ID, c.Name, c.SecretHash, c.Active, c.Public, c.Domain, c.OwnerUserID, c.Perms, c.DateCreated, c.DateUpdated); err != nil {
+		return Client{}, "", errors.Wrap(err, "inserting client")
+	}
+
+	return c, secret, nil
+}
+
+// RotateSecret replaces clientID's secret, invalidating the old one.
+func (cs clientService) RotateSecret(ctx context.Context, traceID string, claims auth.Claims, clientID string, now time.Time) (string, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.client.rotateSecret")
+	defer span.End()
+
+	c, err := cs.getByID(ctx, clientID)
+	if err != nil {
+		return "", err
+	}
+
+	if !claims.HasRole(auth.RoleAdmin) && claims.Subject != c.OwnerUserID {
+		return "", ErrForbidden
+	}
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return "", err
+	}
+
+	const q = `UPDATE clients SET secret_hash = $1, date_updated = $2 WHERE client_id = $3`
+	if _, err := cs.db.ExecContext(ctx, q, hash, now.UTC(), clientID); err != nil {
+		return "", errors.Wrap(err, "rotating client secret")
+	}
+
+	return secret, nil
+}
+
+// Revoke deactivates clientID so it can no longer authenticate.
+func (cs clientService) Revoke(ctx context.Context, traceID string, claims auth.Claims, clientID string) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.client.revoke")
+	defer span.End()
+
+	c, err := cs.getByID(ctx, clientID)
+	if err != nil {
+		return err
+	}
+
+	if !claims.HasRole(auth.RoleAdmin) && claims.Subject != c.OwnerUserID {
+		return ErrForbidden
+	}
+
+	const q = `UPDATE clients SET active = false, date_updated = $1 WHERE client_id = $2`
+	if _, err := cs.db.ExecContext(ctx, q, time.Now().UTC(), clientID); err != nil {
+		return errors.Wrap(err, "revoking client")
+	}
+
+	return nil
+}
+
+// Authenticate verifies a client id/secret pair and, on success, returns a
+// signed access token scoped to that client's permissions rather than any
+// user role.
+func (cs clientService) Authenticate(ctx context.Context, traceID string, clientID, clientSecret string, now time.Time) (AccessToken, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.client.authenticate")
+	defer span.End()
+
+	c, err := cs.getByID(ctx, clientID)
+	if err != nil {
+		if err == ErrNotFound {
+			return AccessToken{}, ErrAuthenticationFailure
+		}
+		return AccessToken{}, err
+	}
+
+	if !c.Active {
+		return AccessToken{}, ErrAuthenticationFailure
+	}
+
+	if err := bcrypt.CompareHashAndPassword(c.SecretHash, []byte(clientSecret)); err != nil {
+		return AccessToken{}, ErrAuthenticationFailure
+	}
+
+	expiresAt := now.Add(clientTokenTTL)
+	claims := auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    "service template",
+			Subject:   c.ID,
+			Audience:  "clients",
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  now.Unix(),
+		},
+		Scope: c.Perms,
+		AMR:   []string{"client_secret"},
+	}
+
+	signed, err := cs.keys.Sign(claims)
+	if err != nil {
+		return AccessToken{}, errors.Wrap(err, "signing access token")
+	}
+
+	return AccessToken{Token: signed, ExpiresAt: expiresAt}, nil
+}
+
+func (cs clientService) getByID(ctx context.Context, clientID string) (Client, error) {
+	const q = `SELECT * FROM clients WHERE client_id = $1`
+
+	var c Client
+	if err := cs.db.GetContext(ctx, &c, q, clientID); err != nil {
+		if err == sql.ErrNoRows {
+			return Client{}, ErrNotFound
+		}
+		return Client{}, errors.Wrapf(err, "selecting client %q", clientID)
+	}
+
+	return c, nil
+}
+
+// newClientSecret generates a random opaque client secret, returning both
+// the raw value (shown once, at registration/rotation time) and its bcrypt
+// hash (what's persisted).
+func newClientSecret() (raw string, hash []byte, err error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", nil, errors.Wrap(err, "generating client secret")
+	}
+	raw = base64.RawURLEncoding.EncodeToString(b)
+
+	hash, err = bcrypt.GenerateFromPassword([]byte(raw), bcrypt.DefaultCost)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "hashing client secret")
+	}
+
+	return raw, hash, nil
+}
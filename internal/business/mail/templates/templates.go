@@ -0,0 +1,55 @@
+// Package templates renders the HTML and text bodies for transactional
+// emails from embedded text/template and html/template sources.
+package templates
+
+import (
+	"bytes"
+	"embed"
+	htemplate "html/template"
+	ttemplate "text/template"
+)
+
+//go:embed *.html.tmpl *.txt.tmpl
+var fs embed.FS
+
+var (
+	htmlTemplates = htemplate.Must(htemplate.ParseFS(fs, "*.html.tmpl"))
+	textTemplates = ttemplate.Must(ttemplate.ParseFS(fs, "*.txt.tmpl"))
+)
+
+// VerificationData is the data available to the verification templates.
+type VerificationData struct {
+	Name string
+	URL  string
+}
+
+// PasswordResetData is the data available to the password reset templates.
+type PasswordResetData struct {
+	Name string
+	URL  string
+}
+
+// Verification renders the HTML and text bodies for an email verification
+// message.
+func Verification(data VerificationData) (html, text string, err error) {
+	return render("verification", data)
+}
+
+// PasswordReset renders the HTML and text bodies for a password reset
+// message.
+func PasswordReset(data PasswordResetData) (html, text string, err error) {
+	return render("password_reset", data)
+}
+
+func render(name string, data interface{}) (html, text string, err error) {
+	var htmlBuf, textBuf bytes.Buffer
+
+	if err := htmlTemplates.ExecuteTemplate(&htmlBuf, name+".html.tmpl", data); err != nil {
+		return "", "", err
+	}
+	if err := textTemplates.ExecuteTemplate(&textBuf, name+".txt.tmpl", data); err != nil {
+		return "", "", err
+	}
+
+	return htmlBuf.String(), textBuf.String(), nil
+}
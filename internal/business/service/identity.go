@@ -0,0 +1,415 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/santiagoh1997/service-template/internal/business/auth"
+	"github.com/santiagoh1997/service-template/internal/business/mail"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrUnknownProvider occurs when a caller references a LoginProvider that
+	// was not registered at construction time.
+	ErrUnknownProvider = errors.New("unknown login provider")
+
+	// ErrInvalidState occurs when the state/PKCE verifier presented on an
+	// OAuth2 callback does not match (or has expired) what was stored when
+	// the flow was initiated. Treated as a potential CSRF attempt.
+	ErrInvalidState = errors.New("invalid or expired oauth state")
+
+	// ErrIdentityInUse occurs when LinkIdentity is called for an external
+	// identity that is already linked to a different user.
+	ErrIdentityInUse = errors.New("external identity already linked to another user")
+)
+
+// ExternalIdentity represents the subset of claims a LoginProvider returns
+// about a user once an authorization code has been exchanged.
+type ExternalIdentity struct {
+	Provider string
+	Subject  string
+	Email    string
+	Name     string
+
+	// EmailVerified reports whether the provider itself asserts ownership
+	// of Email (e.g. the OIDC "email_verified" claim), as opposed to it
+	// merely being on file. provisionFromIdentity only trusts Email to
+	// auto-link to an existing account when this is true.
+	EmailVerified bool
+}
+
+// LoginProvider exchanges an OAuth2/OIDC authorization code for the caller's
+// identity. Implementations handle the provider-specific token exchange and
+// userinfo/ID-token verification.
+type LoginProvider interface {
+	Name() string
+
+	// AuthURL returns the URL to send the caller to in order to start a
+	// login, embedding state and the PKCE code challenge derived from the
+	// verifier BeginExternalLogin generated for this attempt.
+	AuthURL(state, codeChallenge string) string
+
+	// AttemptLogin exchanges an authorization code for the caller's
+	// identity, presenting verifier as the PKCE code_verifier so the
+	// provider can confirm it matches the code_challenge sent to AuthURL.
+	AttemptLogin(ctx context.Context, code, verifier string) (ExternalIdentity, error)
+}
+
+// StateStore persists the PKCE code verifier/state pair issued when an
+// external login flow starts, so the callback can be matched to the request
+// that initiated it and replayed/forged callbacks are rejected.
+type StateStore interface {
+	Save(ctx context.Context, state string, verifier string, ttl time.Duration) error
+	Consume(ctx context.Context, state string) (verifier string, err error)
+}
+
+// Options configures optional dependencies of a UserService at construction
+// time. It is populated via the With* functions below and passed to
+// NewBasicService/New.
+type Options struct {
+	providers  map[string]LoginProvider
+	stateStore StateStore
+	mfaKey     [32]byte
+	mfaTickets StateStore
+	mailer     mail.Sender
+	baseURL    string
+	keyStore   auth.KeyStore
+}
+
+// Option mutates Options. Additional With* functions should follow this
+// pattern as new optional dependencies are introduced.
+type Option func(*Options)
+
+// WithLoginProviders registers the set of external identity providers a
+// UserService can authenticate against, keyed by their Name().
+func WithLoginProviders(providers ...LoginProvider) Option {
+	return func(o *Options) {
+		if o.providers == nil {
+			o.providers = make(map[string]LoginProvider)
+		}
+		for _, p := range providers {
+			o.providers[p.Name()] = p
+		}
+	}
+}
+
+// WithStateStore sets the backing store for OAuth2 state/PKCE verifiers. If
+// omitted, NewBasicService falls back to an in-memory store, which is only
+// suitable for single-instance deployments.
+func WithStateStore(store StateStore) Option {
+	return func(o *Options) {
+		o.stateStore = store
+	}
+}
+
+// WithMFAEncryptionKey sets the AES-256 key used to encrypt TOTP secrets at
+// rest. It is produced and rotated by the `genkey` service-admin command.
+func WithMFAEncryptionKey(key [32]byte) Option {
+	return func(o *Options) {
+		o.mfaKey = key
+	}
+}
+
+// WithMFATicketStore sets the backing store for the short-lived tickets
+// issued by Authenticate while a login is pending a second factor. If
+// omitted, NewBasicService falls back to an in-memory store, which is only
+// suitable for single-instance deployments.
+func WithMFATicketStore(store StateStore) Option {
+	return func(o *Options) {
+		o.mfaTickets = store
+	}
+}
+
+// WithMailer sets the transport used to deliver verification and
+// password-reset emails. If omitted, NewBasicService falls back to a
+// no-op sender, so emails silently go nowhere until one is configured.
+func WithMailer(sender mail.Sender) Option {
+	return func(o *Options) {
+		o.mailer = sender
+	}
+}
+
+// WithBaseURL sets the public base URL used to build links embedded in
+// outbound email, e.g. "https://app.example.com".
+func WithBaseURL(url string) Option {
+	return func(o *Options) {
+		o.baseURL = url
+	}
+}
+
+// WithKeyStore sets the KeyStore used to sign and verify access tokens.
+// See auth.LoadKeyStore for loading one from the keys produced by the
+// genkey command.
+func WithKeyStore(ks auth.KeyStore) Option {
+	return func(o *Options) {
+		o.keyStore = ks
+	}
+}
+
+// oauthStateTTL bounds how long a state/verifier pair issued by
+// BeginExternalLogin stays valid, i.e. how long a caller has to complete the
+// provider's login page and return for the callback.
+const oauthStateTTL = 10 * time.Minute
+
+// BeginExternalLogin starts an OIDC/OAuth2 login against the named
+// provider: it generates a PKCE code verifier and a random state value,
+// saves the pair so the eventual callback can be matched back to this
+// request, and returns the URL to send the caller to plus the state it
+// must round-trip (e.g. in a short-lived cookie) to AuthenticateExternal.
+func (us userService) BeginExternalLogin(ctx context.Context, provider string) (authURL, state string, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.beginExternalLogin")
+	defer span.End()
+
+	p, ok := us.providers[provider]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	verifier, err := newPKCEVerifier()
+	if err != nil {
+		return "", "", errors.Wrap(err, "generating pkce verifier")
+	}
+
+	state, err = newOAuthState()
+	if err != nil {
+		return "", "", errors.Wrap(err, "generating oauth state")
+	}
+
+	if err := us.states.Save(ctx, state, verifier, oauthStateTTL); err != nil {
+		return "", "", errors.Wrap(err, "saving oauth state")
+	}
+
+	return p.AuthURL(state, pkceChallengeS256(verifier)), state, nil
+}
+
+// AuthenticateExternal completes an OIDC/OAuth2 login: it validates the
+// callback state, exchanges the code for an ExternalIdentity via the named
+// provider, and resolves it to a local user, provisioning one just-in-time
+// if this is the first time we've seen the identity.
+func (us userService) AuthenticateExternal(ctx context.Context, traceID string, now time.Time, provider, code, state string) (AccessToken, RefreshToken, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.authenticateExternal")
+	defer span.End()
+
+	p, ok := us.providers[provider]
+	if !ok {
+		return AccessToken{}, RefreshToken{}, ErrUnknownProvider
+	}
+
+	verifier, err := us.states.Consume(ctx, state)
+	if err != nil {
+		return AccessToken{}, RefreshToken{}, ErrInvalidState
+	}
+
+	ident, err := p.AttemptLogin(ctx, code, verifier)
+	if err != nil {
+		return AccessToken{}, RefreshToken{}, errors.Wrap(err, "exchanging authorization code")
+	}
+
+	u, err := us.getByIdentity(ctx, ident.Provider, ident.Subject)
+	if err != nil {
+		if err != ErrNotFound {
+			return AccessToken{}, RefreshToken{}, errors.Wrap(err, "looking up linked identity")
+		}
+
+		u, err = us.provisionFromIdentity(ctx, traceID, ident, now)
+		if err != nil {
+			return AccessToken{}, RefreshToken{}, err
+		}
+	}
+
+	familyID := uuid.New().String()
+	return us.issueTokenPair(ctx, u, []string{"federated"}, familyID, nil)
+}
+
+// provisionFromIdentity creates a local user for an external identity seen
+// for the first time. If a local account with the identity's email already
+// exists, the identity is linked to it instead of creating a duplicate
+// user, but only when both sides have the email verified: the provider
+// must assert ident.EmailVerified and the local account must already have
+// EmailVerifiedAt set. Without that, auto-linking would let an attacker
+// pre-register an unverified external identity against a victim's email
+// and take over their existing account; we provision a fresh account
+// instead and leave linking to the explicit LinkIdentity flow.
+func (us userService) provisionFromIdentity(ctx context.Context, traceID string, ident ExternalIdentity, now time.Time) (User, error) {
+	if ident.Email != "" && ident.EmailVerified {
+		if existing, err := us.getByEmail(ctx, traceID, ident.Email); err == nil && existing.EmailVerifiedAt != nil {
+			if err := us.insertIdentity(ctx, ident, existing.ID); err != nil {
+				return User{}, err
+			}
+			return existing, nil
+		}
+	}
+
+	u := User{
+		ID:          uuid.New().String(),
+		Name:        ident.Name,
+		Email:       ident.Email,
+		Roles:       []string{auth.RoleUser},
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO users
+		(user_id, email, roles, name, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	if _, err := us.db.ExecContext(ctx, q, u.ID, u.Email, u.Roles, u.Name, u.DateCreated, u.DateUpdated); err != nil {
+		return User{}, errors.Wrap(err, "inserting user from external identity")
+	}
+
+	if err := us.insertIdentity(ctx, ident, u.ID); err != nil {
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+func (us userService) insertIdentity(ctx context.Context, ident ExternalIdentity, userID string) error {
+	const q = `INSERT INTO users_identities
+		(provider, external_subject, user_id, date_created)
+		VALUES ($1, $2, $3, $4)
+	`
+	if _, err := us.db.ExecContext(ctx, q, ident.Provider, ident.Subject, userID, time.Now().UTC()); err != nil {
+		return errors.Wrap(err, "linking external identity")
+	}
+
+	return nil
+}
+
+func (us userService) getByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	const q = `
+	SELECT
+		u.*
+	FROM
+		users AS u
+	JOIN
+		users_identities AS i ON i.user_id = u.user_id
+	WHERE
+		i.provider = $1 AND i.external_subject = $2`
+
+	var u User
+	if err := us.db.GetContext(ctx, &u, q, provider, subject); err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, ErrNotFound
+		}
+		return User{}, errors.Wrapf(err, "selecting user for identity %s/%s", provider, subject)
+	}
+
+	return u, nil
+}
+
+// LinkIdentity attaches an external identity to userID. The caller must be
+// the user themselves or an admin.
+func (us userService) LinkIdentity(ctx context.Context, traceID string, claims auth.Claims, userID string, ident ExternalIdentity) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.linkIdentity")
+	defer span.End()
+
+	if !claims.HasRole(auth.RoleAdmin) && claims.Subject != userID {
+		return ErrForbidden
+	}
+
+	if existing, err := us.getByIdentity(ctx, ident.Provider, ident.Subject); err == nil && existing.ID != userID {
+		return ErrIdentityInUse
+	}
+
+	return us.insertIdentity(ctx, ident, userID)
+}
+
+// UnlinkIdentity removes a previously linked external identity from
+// userID.
+func (us userService) UnlinkIdentity(ctx context.Context, traceID string, claims auth.Claims, userID, provider string) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.unlinkIdentity")
+	defer span.End()
+
+	if !claims.HasRole(auth.RoleAdmin) && claims.Subject != userID {
+		return ErrForbidden
+	}
+
+	const q = `DELETE FROM users_identities WHERE user_id = $1 AND provider = $2`
+	if _, err := us.db.ExecContext(ctx, q, userID, provider); err != nil {
+		return errors.Wrapf(err, "unlinking %s identity from user %s", provider, userID)
+	}
+
+	return nil
+}
+
+// newPKCEVerifier generates a random PKCE code verifier, per RFC 7636.
+func newPKCEVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallengeS256 derives the S256 code challenge to send to AuthURL from
+// a verifier generated by newPKCEVerifier.
+func pkceChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// newOAuthState generates the random, unguessable state value embedded in
+// the authorization URL and echoed back on the callback.
+func newOAuthState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// memStateStore is a single-process, in-memory StateStore. It is the
+// default used when NewBasicService is not given WithStateStore, and is
+// only suitable when the service runs as a single instance: a multi-node
+// deployment needs a shared store (e.g. Redis) or callbacks may land on a
+// node that never saw the initiating request.
+type memStateStore struct {
+	mu      sync.Mutex
+	entries map[string]stateEntry
+}
+
+type stateEntry struct {
+	verifier string
+	expires  time.Time
+}
+
+func newMemStateStore() *memStateStore {
+	return &memStateStore{entries: make(map[string]stateEntry)}
+}
+
+func (s *memStateStore) Save(ctx context.Context, state, verifier string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[state] = stateEntry{verifier: verifier, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memStateStore) Consume(ctx context.Context, state string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[state]
+	if !ok {
+		return "", ErrInvalidState
+	}
+	delete(s.entries, state)
+
+	if time.Now().After(e.expires) {
+		return "", ErrInvalidState
+	}
+
+	return e.verifier, nil
+}
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/santiagoh1997/service-template/internal/business/auth"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer   = "service template"
+	totpPeriod   = 30 // seconds
+	totpSkew     = 1  // steps of leeway on either side, for clock drift
+	mfaTicketTTL = 5 * time.Minute
+	recoveryN    = 10 // number of recovery codes issued on enrollment
+)
+
+var (
+	// ErrMFAAlreadyEnrolled occurs when EnrollTOTP is called for a user that
+	// already has a confirmed TOTP secret.
+	ErrMFAAlreadyEnrolled = errors.New("TOTP already enrolled")
+
+	// ErrMFANotEnrolled occurs when ConfirmTOTP, DisableTOTP or a login
+	// attempt references a user with no TOTP secret on file.
+	ErrMFANotEnrolled = errors.New("TOTP not enrolled")
+
+	// ErrMFAInvalidCode occurs when a TOTP or recovery code fails
+	// verification.
+	ErrMFAInvalidCode = errors.New("invalid or expired code")
+
+	// ErrMFATicketInvalid occurs when AuthenticateMFA is called with a
+	// ticket that is unknown, expired, or already redeemed.
+	ErrMFATicketInvalid = errors.New("invalid or expired mfa ticket")
+)
+
+// EnrollTOTP starts TOTP enrollment for userID: it generates a new secret
+// and a set of one-time recovery codes, encrypts the secret at rest, and
+// stores it unconfirmed until ConfirmTOTP proves the user has it loaded in
+// an authenticator app.
+func (us userService) EnrollTOTP(ctx context.Context, claims auth.Claims, userID string) (secret string, qrURL string, recoveryCodes []string, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.enrollTOTP")
+	defer span.End()
+
+	if !claims.HasRole(auth.RoleAdmin) && claims.Subject != userID {
+		return "", "", nil, ErrForbidden
+	}
+
+	var confirmed sql.NullTime
+	const qExisting = `SELECT confirmed_at FROM user_mfa WHERE user_id = $1`
+	if err := us.db.QueryRowContext(ctx, qExisting, userID).Scan(&confirmed); err != nil && err != sql.ErrNoRows {
+		return "", "", nil, errors.Wrap(err, "checking existing TOTP enrollment")
+	}
+	if confirmed.Valid {
+		return "", "", nil, ErrMFAAlreadyEnrolled
+	}
+
+	u, err := us.getByID(ctx, userID)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: u.Email,
+		Period:      totpPeriod,
+	})
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, "generating TOTP secret")
+	}
+
+	ciphertext, err := us.encryptMFASecret(key.Secret())
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	recoveryCodes = make([]string, recoveryN)
+	hashes := make([]string, recoveryN)
+	for i := range recoveryCodes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return "", "", nil, errors.Wrap(err, "generating recovery code")
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return "", "", nil, errors.Wrap(err, "hashing recovery code")
+		}
+		recoveryCodes[i] = code
+		hashes[i] = string(hash)
+	}
+
+	const q = `INSERT INTO user_mfa
+		(user_id, secret_ciphertext, confirmed_at, recovery_codes_hash)
+		VALUES ($1, $2, NULL, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret_ciphertext = EXCLUDED.secret_ciphertext,
+			confirmed_at = NULL,
+			recovery_codes_hash = EXCLUDED.recovery_codes_hash
+	`
+	if _, err := us.db.ExecContext(ctx, q, userID, ciphertext, hashes); err != nil {
+		return "", "", nil, errors.Wrap(err, "storing TOTP enrollment")
+	}
+
+	return key.Secret(), key.URL(), recoveryCodes, nil
+}
+
+// ConfirmTOTP verifies a code against a pending enrollment and, on success,
+// marks it confirmed so future logins require it.
+func (us userService) ConfirmTOTP(ctx context.Context, claims auth.Claims, userID, code string) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.confirmTOTP")
+	defer span.End()
+
+	if !claims.HasRole(auth.RoleAdmin) && claims.Subject != userID {
+		return ErrForbidden
+	}
+
+	secret, err := us.decryptedSecret(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	ok, err := validateTOTPCode(code, secret)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrMFAInvalidCode
+	}
+
+	const q = `UPDATE user_mfa SET confirmed_at = $1 WHERE user_id = $2`
+	if _, err := us.db.ExecContext(ctx, q, time.Now().UTC(), userID); err != nil {
+		return errors.Wrap(err, "confirming TOTP enrollment")
+	}
+
+	return nil
+}
+
+// DisableTOTP removes a confirmed TOTP enrollment for userID after
+// verifying a current code.
+func (us userService) DisableTOTP(ctx context.Context, claims auth.Claims, userID, code string) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.disableTOTP")
+	defer span.End()
+
+	if !claims.HasRole(auth.RoleAdmin) && claims.Subject != userID {
+		return ErrForbidden
+	}
+
+	secret, err := us.decryptedSecret(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	ok, err := validateTOTPCode(code, secret)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrMFAInvalidCode
+	}
+
+	const q = `DELETE FROM user_mfa WHERE user_id = $1`
+	if _, err := us.db.ExecContext(ctx, q, userID); err != nil {
+		return errors.Wrap(err, "disabling TOTP")
+	}
+
+	return nil
+}
+
+// AuthenticateMFA redeems a ticket issued by Authenticate for a user
+// enrolled in TOTP, completing the login. code may be either a current
+// TOTP code or one of the user's unused recovery codes.
+func (us userService) AuthenticateMFA(ctx context.Context, ticket, code string) (AccessToken, RefreshToken, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.authenticateMFA")
+	defer span.End()
+
+	userID, err := us.mfaTickets.Consume(ctx, ticket)
+	if err != nil {
+		return AccessToken{}, RefreshToken{}, ErrMFATicketInvalid
+	}
+
+	secret, err := us.decryptedSecret(ctx, userID)
+	if err != nil {
+		return AccessToken{}, RefreshToken{}, err
+	}
+
+	ok, err := validateTOTPCode(code, secret)
+	if err != nil {
+		return AccessToken{}, RefreshToken{}, err
+	}
+	if !ok {
+		if !us.consumeRecoveryCode(ctx, userID, code) {
+			return AccessToken{}, RefreshToken{}, ErrMFAInvalidCode
+		}
+	}
+
+	u, err := us.getByID(ctx, userID)
+	if err != nil {
+		return AccessToken{}, RefreshToken{}, err
+	}
+
+	familyID := uuid.New().String()
+	return us.issueTokenPair(ctx, u, []string{"pwd", "otp"}, familyID, nil)
+}
+
+// consumeRecoveryCode checks code against userID's unused recovery code
+// hashes and, if it matches one, removes that hash so it cannot be reused
+// again. The match-and-remove happens inside a transaction so concurrent
+// logins can't both succeed with the same code.
+func (us userService) consumeRecoveryCode(ctx context.Context, userID, code string) bool {
+	tx, err := us.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false
+	}
+	defer tx.Rollback()
+
+	var hashes []string
+	const qSelect = `SELECT recovery_codes_hash FROM user_mfa WHERE user_id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, qSelect, userID).Scan(&hashes); err != nil {
+		return false
+	}
+
+	matchIdx := matchRecoveryCode(hashes, code)
+	if matchIdx == -1 {
+		return false
+	}
+
+	remaining := append(hashes[:matchIdx], hashes[matchIdx+1:]...)
+	const qUpdate = `UPDATE user_mfa SET recovery_codes_hash = $1 WHERE user_id = $2`
+	if _, err := tx.ExecContext(ctx, qUpdate, remaining, userID); err != nil {
+		return false
+	}
+
+	return tx.Commit() == nil
+}
+
+// matchRecoveryCode returns the index of the first hash in hashes that code
+// bcrypt-matches, or -1 if none do.
+func matchRecoveryCode(hashes []string, code string) int {
+	for i, h := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(h), []byte(code)) == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateTOTPCode checks code against secret using totpPeriod/totpSkew,
+// rather than totp.Validate's parameterless convenience wrapper, so the
+// configured clock-drift leeway is actually applied.
+func validateTOTPCode(code, secret string) (bool, error) {
+	ok, err := totp.ValidateCustom(code, secret, time.Now().UTC(), totp.ValidateOpts{
+		Period:    totpPeriod,
+		Skew:      totpSkew,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "validating TOTP code")
+	}
+
+	return ok, nil
+}
+
+func (us userService) decryptedSecret(ctx context.Context, userID string) (string, error) {
+	var ciphertext []byte
+	const q = `SELECT secret_ciphertext FROM user_mfa WHERE user_id = $1`
+	if err := us.db.QueryRowContext(ctx, q, userID).Scan(&ciphertext); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrMFANotEnrolled
+		}
+		return "", errors.Wrap(err, "loading TOTP secret")
+	}
+
+	return us.decryptMFASecret(ciphertext)
+}
+
+// getByID fetches a User bypassing the claims-based authorization GetByID
+// enforces; it is used internally once authorization has already been
+// checked by the caller (or the caller is the auth flow itself, which has
+// no claims yet).
+func (us userService) getByID(ctx context.Context, userID string) (User, error) {
+	const q = `SELECT * FROM users WHERE user_id = $1`
+
+	var u User
+	if err := us.db.GetContext(ctx, &u, q, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, ErrNotFound
+		}
+		return User{}, errors.Wrapf(err, "selecting user %q", userID)
+	}
+
+	return u, nil
+}
+
+func (us userService) encryptMFASecret(plaintext string) ([]byte, error) {
+	block, err := aes.NewCipher(us.mfaKey[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing cipher")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing AEAD")
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "generating nonce")
+	}
+
+	return gcm.Seal(nonce, nonce, []byte(plaintext), nil), nil
+}
+
+func (us userService) decryptMFASecret(ciphertext []byte) (string, error) {
+	block, err := aes.NewCipher(us.mfaKey[:])
+	if err != nil {
+		return "", errors.Wrap(err, "constructing cipher")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", errors.Wrap(err, "constructing AEAD")
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return "", errors.New("malformed TOTP ciphertext")
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "decrypting TOTP secret")
+	}
+
+	return string(plaintext), nil
+}
+
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+
+	enc := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))
+	return fmt.Sprintf("%s-%s-%s", enc[0:4], enc[4:8], enc[8:12]), nil
+}
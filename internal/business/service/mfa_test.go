@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMatchRecoveryCode(t *testing.T) {
+	codes := []string{"aaaa-bbbb-cccc", "dddd-eeee-ffff", "gggg-hhhh-iiii"}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		h, err := bcrypt.GenerateFromPassword([]byte(c), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("hashing recovery code: %s", err)
+		}
+		hashes[i] = string(h)
+	}
+
+	if got := matchRecoveryCode(hashes, codes[1]); got != 1 {
+		t.Errorf("matchRecoveryCode(%q) = %d, want 1", codes[1], got)
+	}
+
+	if got := matchRecoveryCode(hashes, "not-a-real-code"); got != -1 {
+		t.Errorf("matchRecoveryCode(unknown code) = %d, want -1", got)
+	}
+
+	if got := matchRecoveryCode(nil, codes[0]); got != -1 {
+		t.Errorf("matchRecoveryCode(no hashes) = %d, want -1", got)
+	}
+}
@@ -0,0 +1,37 @@
+package service
+
+import "time"
+
+// User represents someone with access to our system.
+type User struct {
+	ID              string     `db:"user_id" json:"id"`
+	Name            string     `db:"name" json:"name"`
+	LastName        string     `db:"last_name" json:"last_name"`
+	Email           string     `db:"email" json:"email"`
+	Country         string     `db:"country" json:"country"`
+	PasswordHash    []byte     `db:"password_hash" json:"-"`
+	Roles           []string   `db:"roles" json:"roles"`
+	EmailVerifiedAt *time.Time `db:"email_verified_at" json:"email_verified_at,omitempty"`
+	DateCreated     time.Time  `db:"date_created" json:"date_created"`
+	DateUpdated     time.Time  `db:"date_updated" json:"date_updated"`
+}
+
+// NewUserRequest contains information needed to create a new User.
+type NewUserRequest struct {
+	Name     string   `json:"name" validate:"required"`
+	LastName string   `json:"last_name" validate:"required"`
+	Email    string   `json:"email" validate:"required,email"`
+	Country  string   `json:"country"`
+	Password string   `json:"password" validate:"required"`
+	Roles    []string `json:"roles" validate:"required"`
+}
+
+// UpdateUserRequest defines what information may be provided to modify an
+// existing User. Update overwrites every one of these fields with the value
+// given, including zero values.
+type UpdateUserRequest struct {
+	Name     string `json:"name" validate:"required"`
+	LastName string `json:"last_name" validate:"required"`
+	Email    string `json:"email" validate:"required,email"`
+	Country  string `json:"country"`
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultPageLimit and maxPageLimit bound UserQuery.Limit: 0 (or a
+// negative value) falls back to the default, anything above the max is
+// clamped down to it.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// ErrInvalidCursor occurs when GetAll is given a Cursor that doesn't
+// decode to a valid (date_created, user_id) position.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// UserQuery describes a page of users to fetch: an opaque keyset Cursor
+// (the zero value starts from the most recent user) plus a set of
+// optional filters, all ANDed together.
+type UserQuery struct {
+	Cursor string
+	Limit  int
+
+	EmailContains string
+	Country       string
+	Role          string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// UserPage is one page of a GetAll result.
+type UserPage struct {
+	Items      []User
+	NextCursor string
+	HasMore    bool
+}
+
+// encodeCursor packs a row's keyset position into the opaque cursor token
+// handed back to callers as UserPage.NextCursor.
+func encodeCursor(dateCreated time.Time, userID string) string {
+	raw := fmt.Sprintf("%d:%s", dateCreated.UnixNano(), userID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor reverses encodeCursor.
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	return time.Unix(0, nanos), parts[1], nil
+}
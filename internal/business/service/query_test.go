@@ -0,0 +1,38 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEncodeDecodeCursorRoundTrip(t *testing.T) {
+	dateCreated := time.Date(2026, 7, 27, 12, 0, 0, 0, time.UTC)
+	userID := "11111111-1111-1111-1111-111111111111"
+
+	cursor := encodeCursor(dateCreated, userID)
+
+	gotDate, gotID, err := decodeCursor(cursor)
+	if err != nil {
+		t.Fatalf("decodeCursor(%q): %s", cursor, err)
+	}
+	if !gotDate.Equal(dateCreated) {
+		t.Errorf("decoded date = %s, want %s", gotDate, dateCreated)
+	}
+	if gotID != userID {
+		t.Errorf("decoded id = %q, want %q", gotID, userID)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	cases := []string{
+		"not-valid-base64!!!",
+		"bm8tY29sb24taGVyZQ", // "no-colon-here", valid base64 but no ":"
+		"",
+	}
+
+	for _, cursor := range cases {
+		if _, _, err := decodeCursor(cursor); err != ErrInvalidCursor {
+			t.Errorf("decodeCursor(%q) err = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
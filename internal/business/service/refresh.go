@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"io"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/santiagoh1997/service-template/internal/business/auth"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	accessTokenTTL  = 10 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// AccessToken is a short-lived, signed JWT presented on every request.
+type AccessToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// RefreshToken is an opaque, server-tracked credential exchanged for a new
+// AccessToken/RefreshToken pair once the access token expires. Each use
+// rotates it: RefreshSession issues a new RefreshToken and retires this
+// one, so a stolen refresh token is only useful until its next legitimate
+// use is detected.
+type RefreshToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// MFARequiredError is returned by Authenticate in place of a token pair
+// when the account has TOTP enrolled: the caller must redeem Ticket via
+// AuthenticateMFA to complete the login.
+type MFARequiredError struct {
+	Ticket string
+}
+
+func (e *MFARequiredError) Error() string {
+	return "multi-factor authentication required"
+}
+
+// ErrInvalidRefreshToken occurs when RefreshSession is called with a token
+// that is unknown, expired, or already revoked.
+var ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// issueTokenPair signs a new access token for u and creates the
+// server-side row for a new refresh token in the given family. Passing a
+// nil parentID starts a new family (a fresh login); passing the id of the
+// refresh token being redeemed links the new one to it, so reuse of a
+// retired token can be detected.
+func (us userService) issueTokenPair(ctx context.Context, u User, amr []string, familyID string, parentID *string) (AccessToken, RefreshToken, error) {
+	now := time.Now()
+
+	claims := auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    "service template",
+			Subject:   u.ID,
+			Audience:  "clients",
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+		Roles: u.Roles,
+		AMR:   amr,
+	}
+
+	signed, err := us.keys.Sign(claims)
+	if err != nil {
+		return AccessToken{}, RefreshToken{}, errors.Wrap(err, "signing access token")
+	}
+
+	rawRefresh, id, err := newOpaqueToken()
+	if err != nil {
+		return AccessToken{}, RefreshToken{}, errors.Wrap(err, "generating refresh token")
+	}
+	refreshExpiresAt := now.Add(refreshTokenTTL)
+
+	const q = `INSERT INTO refresh_tokens
+		(id, user_id, family_id, parent_id, expires_at, revoked_at)
+		VALUES ($1, $2, $3, $4, $5, NULL)
+	`
+	if _, err := us.db.ExecContext(ctx, q, id, u.ID, familyID, parentID, refreshExpiresAt); err != nil {
+		return AccessToken{}, RefreshToken{}, errors.Wrap(err, "storing refresh token")
+	}
+
+	return AccessToken{Token: signed, ExpiresAt: now.Add(accessTokenTTL)},
+		RefreshToken{Token: rawRefresh, ExpiresAt: refreshExpiresAt},
+		nil
+}
+
+// RefreshSession redeems rawRefreshToken for a new access/refresh token
+// pair. If the token was already rotated away by a previous call (i.e. it
+// is presented a second time), that's treated as evidence of token theft
+// and the whole family is revoked, forcing the legitimate user to log in
+// again.
+func (us userService) RefreshSession(ctx context.Context, rawRefreshToken string) (AccessToken, RefreshToken, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.refreshSession")
+	defer span.End()
+
+	id := hashToken(rawRefreshToken)
+	now := time.Now().UTC()
+
+	var (
+		userID    string
+		familyID  string
+		expiresAt time.Time
+	)
+	const qRevoke = `
+	UPDATE refresh_tokens
+	SET revoked_at = $1
+	WHERE id = $2 AND revoked_at IS NULL
+	RETURNING user_id, family_id, expires_at`
+	err := us.db.QueryRowContext(ctx, qRevoke, now, id).Scan(&userID, &familyID, &expiresAt)
+	switch {
+	case err == nil:
+		// Claimed: we're the sole caller to retire this token, so it's
+		// safe to mint its successor below.
+	case err == sql.ErrNoRows:
+		return AccessToken{}, RefreshToken{}, us.handleRefreshReuse(ctx, id)
+	default:
+		return AccessToken{}, RefreshToken{}, errors.Wrap(err, "retiring refresh token")
+	}
+
+	if now.After(expiresAt) {
+		return AccessToken{}, RefreshToken{}, ErrInvalidRefreshToken
+	}
+
+	u, err := us.getByID(ctx, userID)
+	if err != nil {
+		return AccessToken{}, RefreshToken{}, err
+	}
+
+	return us.issueTokenPair(ctx, u, []string{"pwd"}, familyID, &id)
+}
+
+// handleRefreshReuse is called when the atomic revoke-if-unrevoked update
+// in RefreshSession claimed no row: id either doesn't exist at all, or it
+// was already revoked by a prior redemption. The latter is evidence of
+// token theft (a stolen token used alongside the legitimate one), so the
+// whole family is revoked, forcing the legitimate user to log in again.
+func (us userService) handleRefreshReuse(ctx context.Context, id string) error {
+	var (
+		familyID  string
+		revokedAt sql.NullTime
+	)
+	const qSelect = `SELECT family_id, revoked_at FROM refresh_tokens WHERE id = $1`
+	if err := us.db.QueryRowContext(ctx, qSelect, id).Scan(&familyID, &revokedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidRefreshToken
+		}
+		return errors.Wrap(err, "selecting refresh token")
+	}
+
+	if revokedAt.Valid {
+		if err := us.revokeFamily(ctx, familyID); err != nil {
+			return errors.Wrap(err, "revoking token family after reuse")
+		}
+	}
+
+	return ErrInvalidRefreshToken
+}
+
+func (us userService) revokeFamily(ctx context.Context, familyID string) error {
+	const q = `UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL`
+	_, err := us.db.ExecContext(ctx, q, time.Now().UTC(), familyID)
+	return err
+}
+
+// JWKS exposes the service's active and recently-retired public signing
+// keys so downstream services can verify access tokens independently.
+func (us userService) JWKS() auth.JWKS {
+	return us.keys.JWKS()
+}
+
+// newOpaqueToken generates a 256-bit random token, returning both the raw
+// value (to hand to the client) and its SHA-256 hash (the value we store
+// and index on, so a database leak doesn't hand out valid refresh tokens).
+func newOpaqueToken() (raw string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", "", err
+	}
+
+	raw = base64.RawURLEncoding.EncodeToString(b)
+	return raw, hashToken(raw), nil
+}
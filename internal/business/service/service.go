@@ -7,12 +7,13 @@ import (
 	"log"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/Masterminds/squirrel"
 	"github.com/go-kit/kit/metrics"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/santiagoh1997/service-template/internal/business/auth"
+	"github.com/santiagoh1997/service-template/internal/business/mail"
 	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -41,25 +42,131 @@ type UserService interface {
 	Create(ctx context.Context, traceID string, nur NewUserRequest, now time.Time) (User, error)
 	Update(ctx context.Context, traceID string, claims auth.Claims, userID string, uur UpdateUserRequest, now time.Time) (User, error)
 	Delete(ctx context.Context, traceID string, claims auth.Claims, userID string) error
-	GetAll(ctx context.Context, traceID string, pageNumber int, rowsPerPage int) ([]User, error)
+
+	// GetAll returns a keyset-paginated, admin-only listing of users.
+	GetAll(ctx context.Context, traceID string, claims auth.Claims, query UserQuery) (UserPage, error)
 	GetByID(ctx context.Context, traceID string, claims auth.Claims, userID string) (User, error)
-	Authenticate(ctx context.Context, traceID string, now time.Time, email, password string) (auth.Claims, error)
+
+	// Authenticate verifies email/password and, on success, returns a
+	// short-lived access token plus a rotating refresh token. If the
+	// account has TOTP enrolled it instead returns an *MFARequiredError
+	// carrying a ticket to complete the login via AuthenticateMFA.
+	Authenticate(ctx context.Context, traceID string, now time.Time, email, password string) (AccessToken, RefreshToken, error)
+
+	// RefreshSession exchanges a refresh token for a new token pair,
+	// rotating it. Presenting a token that was already rotated away
+	// revokes its entire family.
+	RefreshSession(ctx context.Context, refreshToken string) (AccessToken, RefreshToken, error)
+
+	// JWKS exposes the service's public signing keys for downstream
+	// verification of access tokens.
+	JWKS() auth.JWKS
+
+	// BeginExternalLogin starts a login against a registered LoginProvider,
+	// returning the URL to send the caller to and the state it must
+	// present back to AuthenticateExternal.
+	BeginExternalLogin(ctx context.Context, provider string) (authURL, state string, err error)
+
+	// AuthenticateExternal logs a user in via a registered LoginProvider,
+	// provisioning a local account on first sign-in.
+	AuthenticateExternal(ctx context.Context, traceID string, now time.Time, provider, code, state string) (AccessToken, RefreshToken, error)
+	LinkIdentity(ctx context.Context, traceID string, claims auth.Claims, userID string, ident ExternalIdentity) error
+	UnlinkIdentity(ctx context.Context, traceID string, claims auth.Claims, userID, provider string) error
+
+	// EnrollTOTP, ConfirmTOTP and DisableTOTP manage a user's TOTP
+	// enrollment. AuthenticateMFA redeems the ticket from a pending
+	// Authenticate call to complete the login.
+	EnrollTOTP(ctx context.Context, claims auth.Claims, userID string) (secret, qrURL string, recoveryCodes []string, err error)
+	ConfirmTOTP(ctx context.Context, claims auth.Claims, userID, code string) error
+	DisableTOTP(ctx context.Context, claims auth.Claims, userID, code string) error
+	AuthenticateMFA(ctx context.Context, ticket, code string) (AccessToken, RefreshToken, error)
+
+	// RequestEmailVerification and ConfirmEmailVerification verify a user
+	// owns the email address on file. RequestPasswordReset and
+	// ResetPassword implement the forgot-password flow. All four are
+	// backed by single-use tokens in user_tokens.
+	RequestEmailVerification(ctx context.Context, userID string) error
+	ConfirmEmailVerification(ctx context.Context, token string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, newPassword string) error
 }
 
 type userService struct {
-	db *sqlx.DB
+	db        *sqlx.DB
+	providers map[string]LoginProvider
+	states    StateStore
+
+	mfaKey     [32]byte
+	mfaTickets StateStore
+
+	mailer  mail.Sender
+	baseURL string
+
+	keys auth.KeyStore
+
+	log *log.Logger
 }
 
 // NewBasicService constructs a UserService for api access.
-func NewBasicService(log *log.Logger, db *sqlx.DB) UserService {
+func NewBasicService(log *log.Logger, db *sqlx.DB, opts ...Option) UserService {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.providers == nil {
+		o.providers = make(map[string]LoginProvider)
+	}
+	if o.stateStore == nil {
+		o.stateStore = newMemStateStore()
+	}
+	if o.mfaTickets == nil {
+		o.mfaTickets = newMemStateStore()
+	}
+	if o.mailer == nil {
+		o.mailer = mail.NewNoopSender()
+	}
+	if o.keyStore == nil {
+		o.keyStore = errKeyStore{}
+	}
+
 	return userService{
-		db: db,
+		db:         db,
+		providers:  o.providers,
+		states:     o.stateStore,
+		mfaKey:     o.mfaKey,
+		mfaTickets: o.mfaTickets,
+		mailer:     o.mailer,
+		baseURL:    o.baseURL,
+		keys:       o.keyStore,
+		log:        log,
 	}
 }
 
+// errKeyStore is the default auth.KeyStore when NewBasicService is not
+// given WithKeyStore. Every method fails with a descriptive error instead
+// of leaving us.keys nil, so a missing signing key configuration is a
+// predictable error the first time a token needs signing rather than a nil
+// interface panic.
+type errKeyStore struct{}
+
+var errNoKeyStore = errors.New("no signing key configured: pass service.WithKeyStore")
+
+func (errKeyStore) Sign(claims auth.Claims) (string, error) {
+	return "", errNoKeyStore
+}
+
+func (errKeyStore) Parse(token string) (auth.Claims, error) {
+	return auth.Claims{}, errNoKeyStore
+}
+
+func (errKeyStore) JWKS() auth.JWKS {
+	return auth.JWKS{}
+}
+
 // New returns a UserService with instrumentation features.
-func New(log *log.Logger, requestCount metrics.Counter, requestLatency metrics.Histogram, db *sqlx.DB) UserService {
-	us := NewBasicService(log, db)
+func New(log *log.Logger, requestCount metrics.Counter, requestLatency metrics.Histogram, db *sqlx.DB, opts ...Option) UserService {
+	us := NewBasicService(log, db, opts...)
 	us = NewInstrumentingDecorator(requestCount, requestLatency, us)
 
 	return us
@@ -108,6 +215,16 @@ func (us userService) Create(ctx context.Context, traceID string, nur NewUserReq
 		return User{}, errors.Wrap(err, "inserting user")
 	}
 
+	// The user row is already committed at this point, so a failure to
+	// send the verification email (e.g. a transient SMTP error) must not
+	// fail signup: the caller would see an error for an account that
+	// actually exists, and a retry would then hit ErrDuplicatedEmail with
+	// no way to recover. Best-effort it instead; the user can ask for a
+	// new verification email via RequestEmailVerification.
+	if err := us.RequestEmailVerification(ctx, u.ID); err != nil {
+		us.log.Printf("create: issuing verification email for user %s: %s", u.ID, err)
+	}
+
 	return u, nil
 }
 
@@ -155,7 +272,7 @@ func (us userService) Delete(ctx context.Context, traceID string, claims auth.Cl
 		return ErrInvalidID
 	}
 
-	if !claims.Authorized(auth.RoleAdmin) && claims.Subject != userID {
+	if !claims.HasRole(auth.RoleAdmin) && claims.Subject != userID {
 		return ErrForbidden
 	}
 
@@ -172,28 +289,80 @@ func (us userService) Delete(ctx context.Context, traceID string, claims auth.Cl
 	return nil
 }
 
-// GetAll retrieves a list of existing users from the DB.
-func (us userService) GetAll(ctx context.Context, traceID string, pageNumber int, rowsPerPage int) ([]User, error) {
+// GetAll retrieves a keyset-paginated, optionally filtered listing of
+// users, ordered most-recently-created first. Restricted to admins.
+func (us userService) GetAll(ctx context.Context, traceID string, claims auth.Claims, query UserQuery) (UserPage, error) {
 	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.getAll")
 	defer span.End()
 
-	const q = `
-	SELECT
-		*
-	FROM
-		users
-	ORDER BY
-		user_id
-	OFFSET $1 ROWS FETCH NEXT $2 ROWS ONLY`
+	if !claims.HasRole(auth.RoleAdmin) {
+		return UserPage{}, ErrForbidden
+	}
+
+	limit := query.Limit
+	switch {
+	case limit <= 0:
+		limit = defaultPageLimit
+	case limit > maxPageLimit:
+		limit = maxPageLimit
+	}
+
+	qb := squirrel.Select("*").From("users").
+		OrderBy("date_created DESC", "user_id DESC").
+		Limit(uint64(limit + 1)).
+		PlaceholderFormat(squirrel.Dollar)
+
+	if query.EmailContains != "" {
+		qb = qb.Where(squirrel.ILike{"email": "%" + query.EmailContains + "%"})
+	}
+	if query.Country != "" {
+		qb = qb.Where(squirrel.Eq{"country": query.Country})
+	}
+	if query.Role != "" {
+		qb = qb.Where(squirrel.Expr("? = ANY(roles)", query.Role))
+	}
+	if query.CreatedAfter != nil {
+		qb = qb.Where(squirrel.GtOrEq{"date_created": *query.CreatedAfter})
+	}
+	if query.CreatedBefore != nil {
+		qb = qb.Where(squirrel.LtOrEq{"date_created": *query.CreatedBefore})
+	}
+	if query.Cursor != "" {
+		cursorDate, cursorID, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return UserPage{}, err
+		}
+		qb = qb.Where(squirrel.Or{
+			squirrel.Lt{"date_created": cursorDate},
+			squirrel.And{
+				squirrel.Eq{"date_created": cursorDate},
+				squirrel.Lt{"user_id": cursorID},
+			},
+		})
+	}
 
-	offset := (pageNumber - 1) * rowsPerPage
+	sqlStr, args, err := qb.ToSql()
+	if err != nil {
+		return UserPage{}, errors.Wrap(err, "building query")
+	}
 
 	users := []User{}
-	if err := us.db.SelectContext(ctx, &users, q, offset, rowsPerPage); err != nil {
-		return nil, errors.Wrap(err, "selecting users")
+	if err := us.db.SelectContext(ctx, &users, sqlStr, args...); err != nil {
+		return UserPage{}, errors.Wrap(err, "selecting users")
+	}
+
+	page := UserPage{HasMore: len(users) > limit}
+	if page.HasMore {
+		users = users[:limit]
 	}
+	page.Items = users
 
-	return users, nil
+	if len(users) > 0 {
+		last := users[len(users)-1]
+		page.NextCursor = encodeCursor(last.DateCreated, last.ID)
+	}
+
+	return page, nil
 }
 
 // GetByID retrieves a User from the DB by its ID.
@@ -205,7 +374,7 @@ func (us userService) GetByID(ctx context.Context, traceID string, claims auth.C
 		return User{}, ErrInvalidID
 	}
 
-	if !claims.Authorized(auth.RoleAdmin) && claims.Subject != userID {
+	if !claims.HasRole(auth.RoleAdmin) && claims.Subject != userID {
 		return User{}, ErrForbidden
 	}
 
@@ -223,37 +392,43 @@ func (us userService) GetByID(ctx context.Context, traceID string, claims auth.C
 }
 
 // Authenticate finds a user by their email and verifies their password. On
-// success it returns a Claims representing the user. The claims can be
-// used to generate a token for future authentication.
-func (us userService) Authenticate(ctx context.Context, traceID string, now time.Time, email, password string) (auth.Claims, error) {
+// success it returns a signed access token and a refresh token to redeem
+// once the access token expires. If the account has TOTP enrolled, it
+// instead returns an *MFARequiredError and the caller must complete the
+// login via AuthenticateMFA.
+func (us userService) Authenticate(ctx context.Context, traceID string, now time.Time, email, password string) (AccessToken, RefreshToken, error) {
 	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.authenticate")
 	defer span.End()
 
 	u, err := us.getByEmail(ctx, traceID, email)
 	if err != nil {
 		if err == ErrNotFound {
-			return auth.Claims{}, ErrAuthenticationFailure
+			return AccessToken{}, RefreshToken{}, ErrAuthenticationFailure
 		}
-		return auth.Claims{}, errors.Wrap(err, "selecting single user")
+		return AccessToken{}, RefreshToken{}, errors.Wrap(err, "selecting single user")
 	}
 
 	if err := bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)); err != nil {
-		return auth.Claims{}, ErrAuthenticationFailure
+		return AccessToken{}, RefreshToken{}, ErrAuthenticationFailure
+	}
+
+	var mfaConfirmed sql.NullTime
+	const qMFA = `SELECT confirmed_at FROM user_mfa WHERE user_id = $1`
+	if err := us.db.QueryRowContext(ctx, qMFA, u.ID).Scan(&mfaConfirmed); err != nil && err != sql.ErrNoRows {
+		return AccessToken{}, RefreshToken{}, errors.Wrap(err, "checking TOTP enrollment")
 	}
 
-	claims := auth.Claims{
-		// TODO: Customize claims to suit the project.
-		StandardClaims: jwt.StandardClaims{
-			Issuer:    "service template",
-			Subject:   u.ID,
-			Audience:  "clients",
-			ExpiresAt: now.Add(time.Hour).Unix(),
-			IssuedAt:  now.Unix(),
-		},
-		Roles: u.Roles,
+	if mfaConfirmed.Valid {
+		ticket := uuid.New().String()
+		if err := us.mfaTickets.Save(ctx, ticket, u.ID, mfaTicketTTL); err != nil {
+			return AccessToken{}, RefreshToken{}, errors.Wrap(err, "issuing mfa ticket")
+		}
+
+		return AccessToken{}, RefreshToken{}, &MFARequiredError{Ticket: ticket}
 	}
 
-	return claims, nil
+	familyID := uuid.New().String()
+	return us.issueTokenPair(ctx, u, []string{"pwd"}, familyID, nil)
 }
 
 // getByEmail retrieves a User in the DB by its email.
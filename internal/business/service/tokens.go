@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/santiagoh1997/service-template/internal/business/mail"
+	"github.com/santiagoh1997/service-template/internal/business/mail/templates"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	tokenPurposeEmailVerification = "email_verification"
+	tokenPurposePasswordReset     = "password_reset"
+
+	verificationTokenTTL  = 24 * time.Hour
+	passwordResetTokenTTL = time.Hour
+)
+
+// ErrTokenInvalid occurs when ConfirmEmailVerification or ResetPassword is
+// given a token that is unknown, expired, or already used.
+var ErrTokenInvalid = errors.New("invalid or expired token")
+
+// RequestEmailVerification issues a fresh verification token for userID and
+// emails it to their address on file.
+func (us userService) RequestEmailVerification(ctx context.Context, userID string) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.requestEmailVerification")
+	defer span.End()
+
+	u, err := us.getByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	rawToken, err := us.issueToken(ctx, u.ID, tokenPurposeEmailVerification, verificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	html, text, err := templates.Verification(templates.VerificationData{
+		Name: u.Name,
+		URL:  us.baseURL + "/verify-email?token=" + rawToken,
+	})
+	if err != nil {
+		return errors.Wrap(err, "rendering verification email")
+	}
+
+	return us.mailer.Send(ctx, mail.Message{
+		To:      u.Email,
+		Subject: "Verify your email address",
+		HTML:    html,
+		Text:    text,
+	})
+}
+
+// ConfirmEmailVerification redeems a token issued by
+// RequestEmailVerification (or Create's initial auto-issued one) and marks
+// the owning user's email as verified.
+func (us userService) ConfirmEmailVerification(ctx context.Context, token string) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.confirmEmailVerification")
+	defer span.End()
+
+	userID, err := us.consumeToken(ctx, token, tokenPurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+
+	const q = `UPDATE users SET email_verified_at = $1 WHERE user_id = $2`
+	if _, err := us.db.ExecContext(ctx, q, time.Now().UTC(), userID); err != nil {
+		return errors.Wrap(err, "marking email verified")
+	}
+
+	return nil
+}
+
+// RequestPasswordReset issues a password reset token for the account with
+// the given email, if one exists, and emails it. To avoid leaking which
+// emails are registered, it returns nil whether or not a matching account
+// was found.
+func (us userService) RequestPasswordReset(ctx context.Context, email string) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.requestPasswordReset")
+	defer span.End()
+
+	u, err := us.getByEmail(ctx, "", email)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil
+		}
+		return errors.Wrap(err, "looking up user by email")
+	}
+
+	rawToken, err := us.issueToken(ctx, u.ID, tokenPurposePasswordReset, passwordResetTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	html, text, err := templates.PasswordReset(templates.PasswordResetData{
+		Name: u.Name,
+		URL:  us.baseURL + "/reset-password?token=" + rawToken,
+	})
+	if err != nil {
+		return errors.Wrap(err, "rendering password reset email")
+	}
+
+	return us.mailer.Send(ctx, mail.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		HTML:    html,
+		Text:    text,
+	})
+}
+
+// ResetPassword redeems a token issued by RequestPasswordReset and sets
+// userID's password to newPassword.
+func (us userService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "business.service.resetPassword")
+	defer span.End()
+
+	userID, err := us.consumeToken(ctx, token, tokenPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "generating password hash")
+	}
+
+	const q = `UPDATE users SET password_hash = $1, date_updated = $2 WHERE user_id = $3`
+	if _, err := us.db.ExecContext(ctx, q, hash, time.Now().UTC(), userID); err != nil {
+		return errors.Wrap(err, "updating password")
+	}
+
+	return nil
+}
+
+// issueToken generates an opaque 256-bit token, storing only its SHA-256
+// hash, and returns the raw value to be delivered out-of-band (email).
+func (us userService) issueToken(ctx context.Context, userID, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", errors.Wrap(err, "generating token")
+	}
+	rawToken := base64.RawURLEncoding.EncodeToString(raw)
+
+	const q = `INSERT INTO user_tokens
+		(token_hash, user_id, purpose, expires_at, used_at)
+		VALUES ($1, $2, $3, $4, NULL)
+	`
+	if _, err := us.db.ExecContext(ctx, q, hashToken(rawToken), userID, purpose, time.Now().Add(ttl).UTC()); err != nil {
+		return "", errors.Wrap(err, "storing token")
+	}
+
+	return rawToken, nil
+}
+
+// consumeToken marks the token matching purpose as used, provided it
+// exists, hasn't expired, and hasn't already been used, and returns the
+// user it was issued to. The update's affected-row count is what enforces
+// single-use semantics, so concurrent redemptions can't both succeed.
+func (us userService) consumeToken(ctx context.Context, rawToken, purpose string) (string, error) {
+	const q = `
+	UPDATE user_tokens
+	SET used_at = $1
+	WHERE token_hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+	RETURNING user_id`
+
+	var userID string
+	if err := us.db.QueryRowContext(ctx, q, time.Now().UTC(), hashToken(rawToken), purpose).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrTokenInvalid
+		}
+		return "", errors.Wrap(err, "consuming token")
+	}
+
+	return userID, nil
+}
+
+func hashToken(rawToken string) string {
+	sum := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(sum[:])
+}